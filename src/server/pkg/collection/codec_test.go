@@ -0,0 +1,99 @@
+package collection
+
+import (
+	"testing"
+
+	"github.com/gogo/protobuf/proto"
+)
+
+func TestProtoBinaryCodecRoundTrip(t *testing.T) {
+	want := nestedTestMessage(3)
+	encoded, err := (ProtoBinaryCodec{}).Encode(want)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	var got testMessage
+	if err := (ProtoBinaryCodec{}).Decode(encoded, &got); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if !proto.Equal(want, &got) {
+		t.Fatalf("round trip mismatch: got %v, want %v", &got, want)
+	}
+}
+
+func TestProtoBinaryCodecDecodeRejectsWrongWireVersion(t *testing.T) {
+	encoded, err := (ProtoBinaryCodec{}).Encode(nestedTestMessage(0))
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	encoded[1] = wireVersion + 1
+
+	var got testMessage
+	if err := (ProtoBinaryCodec{}).Decode(encoded, &got); err == nil {
+		t.Fatalf("Decode accepted an unsupported wire version")
+	}
+}
+
+func TestMigratingCodecReadsBothFormats(t *testing.T) {
+	want := nestedTestMessage(2)
+
+	binEncoded, err := (ProtoBinaryCodec{}).Encode(want)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	var fromBinary testMessage
+	if err := (MigratingCodec{}).Decode(binEncoded, &fromBinary); err != nil {
+		t.Fatalf("Decode (binary): %v", err)
+	}
+	if !proto.Equal(want, &fromBinary) {
+		t.Fatalf("Decode (binary) mismatch: got %v, want %v", &fromBinary, want)
+	}
+
+	textEncoded := []byte(proto.CompactTextString(want))
+	var fromText testMessage
+	if err := (MigratingCodec{}).Decode(textEncoded, &fromText); err != nil {
+		t.Fatalf("Decode (legacy text): %v", err)
+	}
+	if !proto.Equal(want, &fromText) {
+		t.Fatalf("Decode (legacy text) mismatch: got %v, want %v", &fromText, want)
+	}
+}
+
+func TestMigratingCodecAlwaysWritesBinary(t *testing.T) {
+	encoded, err := (MigratingCodec{}).Encode(nestedTestMessage(1))
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if _, ok := wireBody(encoded); !ok {
+		t.Fatalf("MigratingCodec.Encode didn't write ProtoBinaryCodec's wire header")
+	}
+}
+
+// BenchmarkProtoBinaryCodec exercises Encode/Decode against a message
+// shaped like the PPS/PFS protos this codec actually stores in
+// production -- a handful of scalar fields plus a repeated sub-message
+// field (mirroring something like Pipeline.Provenance) -- without
+// depending on those packages, which this trimmed tree doesn't vendor.
+func BenchmarkProtoBinaryCodec(b *testing.B) {
+	msg := &testMessage{
+		Name: "realistic-pipeline-name",
+		Children: []*testMessage{
+			nestedTestMessage(2),
+			nestedTestMessage(2),
+			nestedTestMessage(2),
+		},
+	}
+	codec := ProtoBinaryCodec{}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		encoded, err := codec.Encode(msg)
+		if err != nil {
+			b.Fatalf("Encode: %v", err)
+		}
+		var decoded testMessage
+		if err := codec.Decode(encoded, &decoded); err != nil {
+			b.Fatalf("Decode: %v", err)
+		}
+	}
+}
@@ -18,9 +18,27 @@ type collection struct {
 	etcdClient *etcd.Client
 	prefix     string
 	indexes    []Index
-}
 
-func NewCollection(etcdClient *etcd.Client, prefix string, indexes []Index) Collection {
+	// expirations tracks the per-key TTLs granted via PutWithTTL /
+	// CreateWithTTL so that expired keys can be proactively surfaced on
+	// watch channels without waiting on etcd's own GC pass.
+	expirations *ttlHeap
+	// expiredSubs fans out a synthetic watch.EventExpired from
+	// watchExpirations, as soon as a tracked key's TTL elapses locally,
+	// to every active Watch() call; each one merges its subscription
+	// into the channel it returns.
+	expiredSubs *expiredEventSubs
+	codec       Codec
+	limits      Limits
+}
+
+// NewCollection creates a collection backed by the given etcd client and
+// keyed under prefix.  codec may be nil to use the default
+// ProtoBinaryCodec, e.g. pass MigratingCodec{} instead to migrate an
+// existing collection off of the legacy text-proto encoding.  limits
+// bounds how large a value (or derived index key) this collection will
+// trust; the zero value leaves all three limits unenforced.
+func NewCollection(etcdClient *etcd.Client, prefix string, indexes []Index, codec Codec, limits Limits) Collection {
 	// We want to ensure that the prefix always ends with a trailing
 	// slash.  Otherwise, when you list the items under a collection
 	// such as `foo`, you might end up listing items under `foobar`
@@ -28,12 +46,21 @@ func NewCollection(etcdClient *etcd.Client, prefix string, indexes []Index) Coll
 	if len(prefix) > 0 && prefix[len(prefix)-1] != '/' {
 		prefix = prefix + "/"
 	}
+	if codec == nil {
+		codec = ProtoBinaryCodec{}
+	}
 
-	return &collection{
-		prefix:     prefix,
-		etcdClient: etcdClient,
-		indexes:    indexes,
+	c := &collection{
+		prefix:      prefix,
+		etcdClient:  etcdClient,
+		indexes:     indexes,
+		expirations: newTTLHeap(),
+		expiredSubs: newExpiredEventSubs(),
+		codec:       codec,
+		limits:      limits,
 	}
+	go c.watchExpirations()
+	return c
 }
 
 func (c *collection) ReadWrite(stm STM) ReadWriteCollection {
@@ -62,12 +89,21 @@ func (c *collection) path(key string) string {
 	return path.Join(c.prefix, key)
 }
 
+// checkLimits rejects data if it violates the collection's Limits, before
+// the caller hands it to a Codec for decoding.
+func (c *collection) checkLimits(data []byte) error {
+	if err := c.limits.checkValueSize(c.prefix, data); err != nil {
+		return err
+	}
+	return c.limits.checkProtoDepth(c.prefix, data)
+}
+
 // See the documentation for `Index` for details.
 func (c *collection) indexDir(index Index, indexVal string) string {
 	indexDir := c.prefix
 	// remove trailing slash
 	indexDir = strings.TrimRight(indexDir, "/")
-	return fmt.Sprintf("%s__index_%s/%s", indexDir, index, indexVal)
+	return fmt.Sprintf("%s__index_%s/%s", indexDir, index.Name, indexVal)
 }
 
 // See the documentation for `Index` for details.
@@ -85,7 +121,11 @@ func (c *readWriteCollection) Get(key string, val proto.Message) error {
 	if valStr == "" {
 		return ErrNotFound{c.prefix, key}
 	}
-	return proto.UnmarshalText(valStr, val)
+	data := []byte(valStr)
+	if err := c.checkLimits(data); err != nil {
+		return err
+	}
+	return c.codec.Decode(data, val)
 }
 
 func cloneProtoMsg(original proto.Message) proto.Message {
@@ -96,34 +136,79 @@ func cloneProtoMsg(original proto.Message) proto.Message {
 	return reflect.New(val.Type()).Interface().(proto.Message)
 }
 
-func (c *readWriteCollection) indexPathFromVal(val proto.Message, index Index, key string) string {
-	r := reflect.ValueOf(val)
-	f := reflect.Indirect(r).FieldByName(string(index)).Interface()
-	indexKey := fmt.Sprintf("%s", f)
-	return c.indexPath(index, indexKey, key)
+// indexPathsFromVal returns the index paths val should be filed under for
+// index, i.e. one path per value index.Extract returns for val.
+func (c *readWriteCollection) indexPathsFromVal(val proto.Message, index Index, key string) []string {
+	indexVals := index.Extract(val)
+	paths := make([]string, len(indexVals))
+	for i, indexVal := range indexVals {
+		indexVal = c.limits.cappedIndexVal(c.prefix, indexVal)
+		paths[i] = c.indexPath(index, indexVal, key)
+	}
+	return paths
+}
+
+func (c *readWriteCollection) Put(key string, val proto.Message) error {
+	return c.put(key, val)
 }
 
-func (c *readWriteCollection) Put(key string, val proto.Message) {
+// put is Put, but threads etcd op options (namely etcd.WithLease) down to
+// the STM's Put call, for PutWithTTL's benefit.
+func (c *readWriteCollection) put(key string, val proto.Message, opts ...etcd.OpOption) error {
 	if c.indexes != nil {
+		var oldVal proto.Message
+		clone := cloneProtoMsg(val)
+		// If we can get the original value, we diff its indexes against
+		// val's below instead of blindly deleting and recreating them,
+		// so that a watcher on an index that didn't actually change
+		// doesn't see a spurious event.
+		if err := c.Get(key, clone); err == nil {
+			oldVal = clone
+		}
 		for _, index := range c.indexes {
-			indexPath := c.indexPathFromVal(val, index, key)
-			clone := cloneProtoMsg(val)
-			// If we can get the original value, we remove the original indexes
-			if err := c.Get(key, clone); err == nil {
-				originalIndexPath := c.indexPathFromVal(clone, index, key)
-				if originalIndexPath != indexPath {
-					c.stm.Del(originalIndexPath)
+			staleIndexPaths := make(map[string]bool)
+			if oldVal != nil {
+				for _, p := range c.indexPathsFromVal(oldVal, index, key) {
+					staleIndexPaths[p] = true
+				}
+			}
+			for _, indexPath := range c.indexPathsFromVal(val, index, key) {
+				if staleIndexPaths[indexPath] {
+					// unchanged; leave it in place
+					delete(staleIndexPaths, indexPath)
+					continue
+				}
+				owner := c.stm.Get(indexPath)
+				if index.Unique && owner != "" && owner != key {
+					return ErrIndexConflict{c.prefix, index.Name, indexPath}
+				}
+				// Only put the index if it doesn't already exist;
+				// otherwise we might trigger an unnecessary event if
+				// someone is watching the index.
+				if owner == "" {
+					c.stm.Put(indexPath, key)
 				}
 			}
-			// Only put the index if it doesn't already exist; otherwise
-			// we might trigger an unnecessary event if someone is
-			// watching the index
-			if c.stm.Get(indexPath) == "" {
-				c.stm.Put(indexPath, key)
+			for staleIndexPath := range staleIndexPaths {
+				c.stm.Del(staleIndexPath)
 			}
 		}
 	}
-	c.stm.Put(c.path(key), val.String())
+	c.expirations.Remove(c.path(key))
+	c.stm.Put(c.path(key), c.mustEncode(val), opts...)
+	return nil
+}
+
+// mustEncode encodes val with the collection's codec.  A well-formed
+// proto.Message cannot fail to encode, so an error here indicates a bug
+// in the caller (e.g. a nil val) rather than a condition callers should
+// handle.
+func (c *collection) mustEncode(val proto.Message) string {
+	data, err := c.codec.Encode(val)
+	if err != nil {
+		panic(fmt.Sprintf("collection: failed to encode value: %v", err))
+	}
+	return string(data)
 }
 
 func (c *readWriteCollection) Create(key string, val proto.Message) error {
@@ -132,7 +217,55 @@ func (c *readWriteCollection) Create(key string, val proto.Message) error {
 	if valStr != "" {
 		return ErrExists{c.prefix, key}
 	}
-	c.Put(key, val)
+	return c.put(key, val)
+}
+
+// PutWithTTL is like Put, but the underlying etcd key is attached to a
+// lease that expires after ttlSeconds, so the key is garbage collected by
+// etcd even if nobody ever deletes it explicitly.  The key's index entries
+// are written without the lease and are not removed when it expires.
+// This is intended for ephemeral data such as worker registrations or
+// job leases.
+func (c *readWriteCollection) PutWithTTL(key string, val proto.Message, ttlSeconds int64) error {
+	lease, err := c.etcdClient.Grant(context.Background(), ttlSeconds)
+	if err != nil {
+		return err
+	}
+	if err := c.put(key, val, etcd.WithLease(lease.ID)); err != nil {
+		return err
+	}
+	c.expirations.Add(c.path(key), ttlSeconds)
+	return nil
+}
+
+// CreateWithTTL is like Create, but grants the key a lease as in
+// PutWithTTL.
+func (c *readWriteCollection) CreateWithTTL(key string, val proto.Message, ttlSeconds int64) error {
+	fullKey := c.path(key)
+	valStr := c.stm.Get(fullKey)
+	if valStr != "" {
+		return ErrExists{c.prefix, key}
+	}
+	return c.PutWithTTL(key, val, ttlSeconds)
+}
+
+// Refresh renews the lease backing key with KeepAliveOnce, which restarts
+// the lease at the TTL it was originally granted with; ttlSeconds only
+// reschedules the local expiration that drives watch.EventExpired.  It
+// returns ErrNotFound if the key has no outstanding lease to refresh.
+func (c *readWriteCollection) Refresh(key string, ttlSeconds int64) error {
+	fullKey := c.path(key)
+	resp, err := c.etcdClient.Get(context.Background(), fullKey)
+	if err != nil {
+		return err
+	}
+	if len(resp.Kvs) == 0 || resp.Kvs[0].Lease == 0 {
+		return ErrNotFound{c.prefix, key}
+	}
+	if _, err := c.etcdClient.KeepAliveOnce(context.Background(), etcd.LeaseID(resp.Kvs[0].Lease)); err != nil {
+		return err
+	}
+	c.expirations.Add(fullKey, ttlSeconds)
 	return nil
 }
 
@@ -143,20 +276,23 @@ func (c *readWriteCollection) Delete(key string, vals ...proto.Message) error {
 	}
 	if c.indexes != nil && len(vals) > 0 {
 		val := vals[0]
-		for _, index := range c.indexes {
-			// If we can get the value, we remove the corresponding indexes
-			if err := c.Get(key, val); err == nil {
-				indexPath := c.indexPathFromVal(val, index, key)
-				c.stm.Del(indexPath)
+		// If we can get the value, we remove the corresponding indexes
+		if err := c.Get(key, val); err == nil {
+			for _, index := range c.indexes {
+				for _, indexPath := range c.indexPathsFromVal(val, index, key) {
+					c.stm.Del(indexPath)
+				}
 			}
 		}
 	}
 	c.stm.Del(fullKey)
+	c.expirations.Remove(fullKey)
 	return nil
 }
 
 func (c *readWriteCollection) DeleteAll() {
 	c.stm.DelAll(c.prefix)
+	c.expirations.RemovePrefix(c.prefix)
 }
 
 type readWriteIntCollection struct {
@@ -234,80 +370,131 @@ func (c *readonlyCollection) Get(key string, val proto.Message) error {
 		return ErrNotFound{c.prefix, key}
 	}
 
-	return proto.UnmarshalText(string(resp.Kvs[0].Value), val)
+	if err := c.checkLimits(resp.Kvs[0].Value); err != nil {
+		return err
+	}
+	return c.codec.Decode(resp.Kvs[0].Value, val)
 }
 
 // an indirect iterator goes through a list of keys and retrieve those
 // items from the collection.
 type indirectIterator struct {
-	index int
-	resp  *etcd.GetResponse
-	col   *readonlyCollection
+	page *kvPage
+	col  *readonlyCollection
 }
 
 func (i *indirectIterator) Next(key *string, val proto.Message) (ok bool, retErr error) {
-	if i.index < len(i.resp.Kvs) {
-		kv := i.resp.Kvs[i.index]
-		i.index += 1
-
-		*key = path.Base(string(kv.Key))
-		if err := i.col.Get(*key, val); err != nil {
-			return false, err
-		}
+	kv, err := i.page.next()
+	if err != nil {
+		return false, err
+	}
+	if kv == nil {
+		return false, nil
+	}
 
-		return true, nil
+	*key = path.Base(string(kv.Key))
+	if err := i.col.Get(*key, val); err != nil {
+		return false, err
 	}
-	return false, nil
+	return true, nil
 }
 
-func (c *readonlyCollection) GetByIndex(index Index, val string) (Iterator, error) {
-	resp, err := c.etcdClient.Get(c.ctx, c.indexDir(index, val), etcd.WithPrefix(), etcd.WithSort(etcd.SortByModRevision, etcd.SortDescend))
-	if err != nil {
-		return nil, err
-	}
+// GetByIndex returns an iterator over the keys filed under index's val,
+// paginated per opts (or defaultPageSize if opts is omitted).
+func (c *readonlyCollection) GetByIndex(index Index, val string, opts ...ListOptions) (Iterator, error) {
 	return &indirectIterator{
-		resp: resp,
+		page: newKVPage(c.ctx, c.etcdClient, c.indexDir(index, val), 0, firstListOptions(opts)),
 		col:  c,
 	}, nil
 }
 
-// List returns an iteraor that can be used to iterate over the collection.
-// The objects are sorted by revision time in descending order, i.e. newer
-// objects are returned first.
-func (c *readonlyCollection) List() (Iterator, error) {
-	resp, err := c.etcdClient.Get(c.ctx, c.prefix, etcd.WithPrefix(), etcd.WithSort(etcd.SortByModRevision, etcd.SortDescend))
-	if err != nil {
-		return nil, err
-	}
+// List returns an iterator that can be used to iterate over the
+// collection. Within a page, objects are sorted by revision time in
+// descending order, i.e. newer objects are returned first; across pages,
+// objects are only guaranteed to be returned in key order (each page is
+// fetched as a contiguous key range, which the cursor relies on to cover
+// the whole collection without gaps), so a collection spanning more than
+// one page is not returned in one global revision order. List pages
+// through the collection via opts (or defaultPageSize if opts is omitted)
+// rather than buffering the whole prefix in memory up front.
+func (c *readonlyCollection) List(opts ...ListOptions) (Iterator, error) {
+	return c.listAtRev(0, firstListOptions(opts))
+}
+
+// ListRev is like List, but pins the listing to revision rev so that
+// every page -- even ones fetched after concurrent writes land -- reflects
+// the same snapshot of the collection.
+func (c *readonlyCollection) ListRev(rev int64, opts ...ListOptions) (Iterator, error) {
+	return c.listAtRev(rev, firstListOptions(opts))
+}
+
+func (c *readonlyCollection) listAtRev(rev int64, opts ListOptions) (Iterator, error) {
 	return &iterator{
-		resp: resp,
+		page: newKVPage(c.ctx, c.etcdClient, c.prefix, rev, opts),
+		col:  c,
 	}, nil
 }
 
+func firstListOptions(opts []ListOptions) ListOptions {
+	if len(opts) == 0 {
+		return ListOptions{}
+	}
+	return opts[0]
+}
+
 type iterator struct {
-	index int
-	resp  *etcd.GetResponse
+	page *kvPage
+	col  *readonlyCollection
 }
 
 func (i *iterator) Next(key *string, val proto.Message) (ok bool, retErr error) {
-	if i.index < len(i.resp.Kvs) {
-		kv := i.resp.Kvs[i.index]
-		i.index += 1
-
-		*key = path.Base(string(kv.Key))
-		if err := proto.UnmarshalText(string(kv.Value), val); err != nil {
-			return false, err
-		}
+	kv, err := i.page.next()
+	if err != nil {
+		return false, err
+	}
+	if kv == nil {
+		return false, nil
+	}
 
-		return true, nil
+	*key = path.Base(string(kv.Key))
+	if err := i.col.checkLimits(kv.Value); err != nil {
+		return false, err
+	}
+	if err := i.col.codec.Decode(kv.Value, val); err != nil {
+		return false, err
 	}
-	return false, nil
+	return true, nil
 }
 
 // Watch a collection, returning the current content of the collection as
-// well as any future additions.
+// well as any future additions.  Keys put with a TTL also generate a
+// watch.EventExpired as soon as the collection notices their lease has
+// elapsed locally, which is typically well before etcd's own lease GC
+// would have deleted the key and produced a watch.EventDelete.
 func (c *readonlyCollection) Watch() watch.EventChan {
-	return watch.Watch(c.ctx, c.etcdClient, c.prefix)
+	etcdEvents := watch.Watch(c.ctx, c.etcdClient, c.prefix)
+	subID, expiredCh := c.expiredSubs.subscribe()
+	eventCh := make(chan *watch.Event)
+	go func() {
+		defer close(eventCh)
+		defer c.expiredSubs.unsubscribe(subID)
+		for {
+			select {
+			case ev, ok := <-etcdEvents:
+				if !ok {
+					return
+				}
+				eventCh <- ev
+			case ev := <-expiredCh:
+				if strings.HasPrefix(string(ev.Key), c.prefix) {
+					eventCh <- ev
+				}
+			case <-c.ctx.Done():
+				return
+			}
+		}
+	}()
+	return eventCh
 }
 
 // WatchByIndex watches items in a collection that match a particular index
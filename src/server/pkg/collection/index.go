@@ -0,0 +1,44 @@
+package collection
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/gogo/protobuf/proto"
+)
+
+// Index describes a secondary index that a collection maintains so items
+// can be looked up by something other than their primary key.
+//
+// Extract returns the set of values val should be filed under.  Most
+// indexes return exactly one value, but a multi-valued field (e.g. a
+// Provenance []Commit) can file the same key under several values at
+// once by returning more than one.
+//
+// When Unique is set, Put and Create refuse to write a value if another
+// key already owns one of the values Extract returns for it, returning
+// ErrIndexConflict.
+type Index struct {
+	Name    string
+	Extract func(val proto.Message) []string
+	Unique  bool
+}
+
+// Field builds a single-valued Index that extracts the named proto field
+// via reflection, matching the lookup behavior of the historical `type
+// Index string` (a bare field name, single-valued, not unique). It is not
+// source-compatible with that type, though -- now that Index is a struct,
+// a call site that declared `var FooIndex collection.Index = "Foo"` won't
+// compile, and needs to change to `var FooIndex = collection.Field("Foo")`.
+// New indexes that need multiple values or uniqueness should construct an
+// Index literal with an explicit Extract function instead.
+func Field(name string) Index {
+	return Index{
+		Name: name,
+		Extract: func(val proto.Message) []string {
+			r := reflect.ValueOf(val)
+			f := reflect.Indirect(r).FieldByName(name).Interface()
+			return []string{fmt.Sprintf("%s", f)}
+		},
+	}
+}
@@ -0,0 +1,29 @@
+package collection
+
+import "testing"
+
+func TestFieldExtractsNamedField(t *testing.T) {
+	idx := Field("Name")
+	got := idx.Extract(&testMessage{Name: "foo"})
+	if len(got) != 1 || got[0] != "foo" {
+		t.Fatalf("Extract = %v, want [\"foo\"]", got)
+	}
+}
+
+func TestIndexPathsFromValCapsIndexKeyLength(t *testing.T) {
+	c := &readWriteCollection{
+		collection: &collection{
+			prefix: "test/",
+			limits: Limits{MaxIndexKeyBytes: 3},
+		},
+	}
+	idx := Field("Name")
+	paths := c.indexPathsFromVal(&testMessage{Name: "toolong"}, idx, "key1")
+	if len(paths) != 1 {
+		t.Fatalf("len(paths) = %d, want 1", len(paths))
+	}
+	want := c.indexPath(idx, "too", "key1")
+	if paths[0] != want {
+		t.Fatalf("indexPathsFromVal = %q, want %q (truncated to MaxIndexKeyBytes)", paths[0], want)
+	}
+}
@@ -0,0 +1,129 @@
+package collection
+
+import "encoding/binary"
+
+// Limits bounds how much a collection will trust a single etcd value
+// before decoding it, guarding against corrupted or malicious etcd data.
+// This follows the same rationale as the depth/size hardening Go 1.19
+// added to encoding/gob, encoding/xml, and compress/gzip to fix
+// stack/memory exhaustion: an attacker (or a corrupted lease) who can get
+// arbitrary bytes into etcd under this collection's prefix shouldn't be
+// able to turn that into unbounded memory or stack usage in every process
+// that reads it back out.
+type Limits struct {
+	// MaxValueBytes rejects any raw value larger than this many bytes
+	// before attempting to decode it. Zero means unlimited.
+	MaxValueBytes int
+	// MaxProtoDepth rejects values whose protobuf wire encoding nests
+	// length-delimited fields deeper than this, which could otherwise
+	// exhaust the stack during proto.Unmarshal. Zero means unlimited.
+	//
+	// This only scans values in ProtoBinaryCodec's wire format. A
+	// collection read through MigratingCodec during its drain-migration
+	// window can still hold legacy text-proto values, which aren't in a
+	// format checkProtoDepth understands how to scan; those values pass
+	// through unchecked until the collection has fully migrated off of
+	// MigratingCodec.
+	MaxProtoDepth int
+	// MaxIndexKeyBytes caps the length of a derived secondary index
+	// key, so a maliciously long indexed field value can't create a
+	// pathological etcd key. Zero means unlimited.
+	MaxIndexKeyBytes int
+}
+
+func (l Limits) checkValueSize(prefix string, data []byte) error {
+	if l.MaxValueBytes > 0 && len(data) > l.MaxValueBytes {
+		valueTooLargeCount.WithLabelValues(prefix).Inc()
+		return ErrValueTooLarge{prefix, len(data), l.MaxValueBytes}
+	}
+	return nil
+}
+
+func (l Limits) checkProtoDepth(prefix string, data []byte) error {
+	if l.MaxProtoDepth <= 0 {
+		return nil
+	}
+	// data still carries this package's 4-byte wire header; scanning it
+	// as protobuf wire format would misinterpret the header bytes as a
+	// bogus tag and return early without ever looking at the actual
+	// payload, so strip it first. Values we can't recognize a header on
+	// (e.g. legacy text proto read via MigratingCodec) aren't in a wire
+	// format wireDepth understands, so there's nothing to scan -- see the
+	// caveat on MaxProtoDepth.
+	body, ok := wireBody(data)
+	if !ok {
+		return nil
+	}
+	if _, err := wireDepth(body, 0, l.MaxProtoDepth); err != nil {
+		protoDepthExceededCount.WithLabelValues(prefix).Inc()
+		return ErrMaxDepthExceeded{prefix, l.MaxProtoDepth}
+	}
+	return nil
+}
+
+// cappedIndexVal truncates indexVal to MaxIndexKeyBytes if it's set and
+// indexVal exceeds it, incrementing a metric so operators can spot a
+// field that's regularly producing oversized index keys.
+func (l Limits) cappedIndexVal(prefix string, indexVal string) string {
+	if l.MaxIndexKeyBytes > 0 && len(indexVal) > l.MaxIndexKeyBytes {
+		indexKeyTruncatedCount.WithLabelValues(prefix).Inc()
+		return indexVal[:l.MaxIndexKeyBytes]
+	}
+	return indexVal
+}
+
+// wireDepth performs a best-effort pre-parse of data as protobuf wire
+// format, recursing into length-delimited fields as though they were
+// nested messages, and returns an error as soon as nesting would exceed
+// max. It doesn't validate that the encoding is otherwise well-formed --
+// on malformed input it simply stops recursing, which is safe because
+// proto.Unmarshal will reject the value on its own right after.
+func wireDepth(data []byte, depth, max int) (int, error) {
+	if depth > max {
+		return depth, ErrMaxDepthExceeded{Depth: depth, Max: max}
+	}
+	deepest := depth
+	for len(data) > 0 {
+		tag, n := binary.Uvarint(data)
+		if n <= 0 {
+			return deepest, nil
+		}
+		data = data[n:]
+		switch tag & 0x7 {
+		case 0: // varint
+			_, n := binary.Uvarint(data)
+			if n <= 0 {
+				return deepest, nil
+			}
+			data = data[n:]
+		case 1: // 64-bit
+			if len(data) < 8 {
+				return deepest, nil
+			}
+			data = data[8:]
+		case 2: // length-delimited: bytes, string, or a nested message
+			l, n := binary.Uvarint(data)
+			if n <= 0 || l > uint64(len(data)-n) {
+				return deepest, nil
+			}
+			data = data[n:]
+			field := data[:l]
+			data = data[l:]
+			sub, err := wireDepth(field, depth+1, max)
+			if err != nil {
+				return sub, err
+			}
+			if sub > deepest {
+				deepest = sub
+			}
+		case 5: // 32-bit
+			if len(data) < 4 {
+				return deepest, nil
+			}
+			data = data[4:]
+		default:
+			return deepest, nil
+		}
+	}
+	return deepest, nil
+}
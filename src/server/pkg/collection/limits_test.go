@@ -0,0 +1,68 @@
+package collection
+
+import (
+	"testing"
+
+	"github.com/gogo/protobuf/proto"
+)
+
+func TestCheckProtoDepthStripsWireHeader(t *testing.T) {
+	limits := Limits{MaxProtoDepth: 3}
+	encoded, err := ProtoBinaryCodec{}.Encode(nestedTestMessage(1))
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	// Before stripping the 4-byte wire header, wireDepth would read the
+	// header's magic/version/codecID bytes as a bogus protobuf tag with
+	// an invalid wire type and bail out immediately, reporting depth 0
+	// and never rejecting anything. Confirm a shallow, normally-encoded
+	// value is accepted...
+	if err := limits.checkProtoDepth("test/", encoded); err != nil {
+		t.Fatalf("checkProtoDepth rejected a shallow message: %v", err)
+	}
+
+	// ...and that a message nested well past MaxProtoDepth is actually
+	// rejected once decoded from its real wire format.
+	deep, err := ProtoBinaryCodec{}.Encode(nestedTestMessage(10))
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if err := limits.checkProtoDepth("test/", deep); err == nil {
+		t.Fatalf("checkProtoDepth did not reject a deeply nested message")
+	}
+}
+
+func TestWireDepthRecursesIntoSubmessages(t *testing.T) {
+	encoded, err := ProtoBinaryCodec{}.Encode(nestedTestMessage(5))
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	body, ok := wireBody(encoded)
+	if !ok {
+		t.Fatalf("wireBody: expected to find a wire header")
+	}
+	if _, err := wireDepth(body, 0, 3); err == nil {
+		t.Fatalf("wireDepth(max=3) did not reject a message nested 5 deep")
+	}
+	// wireDepth treats every length-delimited field as a nesting level,
+	// strings included, so the leaf's Name sits one level below the fifth
+	// nested message: depth 6 in all.
+	if _, err := wireDepth(body, 0, 6); err != nil {
+		t.Fatalf("wireDepth(max=6) rejected a message nested 5 deep (leaf Name at depth 6): %v", err)
+	}
+}
+
+// TestCheckProtoDepthDoesNotScanLegacyTextProto documents a known gap,
+// called out on Limits.MaxProtoDepth: checkProtoDepth only understands
+// ProtoBinaryCodec's wire format, so a legacy text-proto value -- the
+// kind MigratingCodec is specifically built to still read during a drain
+// migration -- passes through with no depth check at all, no matter how
+// deeply nested it is.
+func TestCheckProtoDepthDoesNotScanLegacyTextProto(t *testing.T) {
+	limits := Limits{MaxProtoDepth: 3}
+	textEncoded := []byte(proto.CompactTextString(nestedTestMessage(10)))
+	if err := limits.checkProtoDepth("test/", textEncoded); err != nil {
+		t.Fatalf("checkProtoDepth unexpectedly rejected a legacy text-proto value: %v", err)
+	}
+}
@@ -0,0 +1,30 @@
+package collection
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	valueTooLargeCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "pachyderm",
+		Subsystem: "collection",
+		Name:      "value_too_large_total",
+		Help:      "Number of etcd values rejected for exceeding Limits.MaxValueBytes.",
+	}, []string{"prefix"})
+	protoDepthExceededCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "pachyderm",
+		Subsystem: "collection",
+		Name:      "proto_depth_exceeded_total",
+		Help:      "Number of etcd values rejected for exceeding Limits.MaxProtoDepth.",
+	}, []string{"prefix"})
+	indexKeyTruncatedCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "pachyderm",
+		Subsystem: "collection",
+		Name:      "index_key_truncated_total",
+		Help:      "Number of derived secondary index keys truncated for exceeding Limits.MaxIndexKeyBytes.",
+	}, []string{"prefix"})
+)
+
+func init() {
+	prometheus.MustRegister(valueTooLargeCount)
+	prometheus.MustRegister(protoDepthExceededCount)
+	prometheus.MustRegister(indexKeyTruncatedCount)
+}
@@ -0,0 +1,186 @@
+package collection
+
+import (
+	"context"
+	"sort"
+
+	etcd "github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+)
+
+// defaultPageSize bounds how many keys a single etcd range read fetches at
+// a time.  List and GetByIndex refill their internal buffer one page at a
+// time instead of buffering an entire collection's KVs up front, which on
+// collections with thousands of entries (pipelines, jobs, ...) could blow
+// memory and hold up other etcd operations.
+const defaultPageSize = 1024
+
+// ListOptions controls how List and GetByIndex page through a collection.
+type ListOptions struct {
+	// PageSize is the number of keys fetched per etcd range read. Zero
+	// means defaultPageSize.
+	PageSize int64
+	// StartKey, if set, resumes a listing at the first key greater than
+	// or equal to StartKey, rather than at the beginning of the
+	// collection's prefix.
+	StartKey string
+	// SortBy orders the results within each page. The zero value sorts
+	// by modification revision, descending, matching the collection's
+	// historical (unpaginated) behavior. This ordering is only applied
+	// page-by-page -- the range itself is always walked in key order, so
+	// that the page cursor (which advances past the last key byte range)
+	// stays valid across an arbitrary number of pages. A collection that
+	// spans more than one page is therefore not returned in a single
+	// global SortBy order, only in per-page order.
+	SortBy etcd.SortTarget
+}
+
+func (o ListOptions) pageSize() int64 {
+	if o.PageSize > 0 {
+		return o.PageSize
+	}
+	return defaultPageSize
+}
+
+func (o ListOptions) sortBy() etcd.SortTarget {
+	if o.SortBy == 0 {
+		return etcd.SortByModRevision
+	}
+	return o.SortBy
+}
+
+// prefixRangeEnd returns the key bounding the end (exclusive) of the range
+// of all keys sharing prefix, by incrementing its final byte -- the same
+// trick etcd.WithPrefix uses internally.
+func prefixRangeEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+		end = end[:i]
+	}
+	// prefix is empty or all 0xff bytes: there's no key that sorts
+	// after it, so match everything.
+	return "\x00"
+}
+
+// kvPage streams the keys in a collection's prefix a page at a time,
+// optionally pinned to a fixed revision so that concurrent writes can't
+// produce a split-brain read where later pages reflect state that didn't
+// exist when earlier pages were read.
+type kvPage struct {
+	ctx        context.Context
+	etcdClient *etcd.Client
+	rangeEnd   string
+	opts       ListOptions
+	rev        int64 // 0 until the first page pins one
+
+	nextKey string
+	buffer  []*mvccpb.KeyValue
+	bufIdx  int
+	done    bool
+}
+
+func newKVPage(ctx context.Context, etcdClient *etcd.Client, prefix string, rev int64, opts ListOptions) *kvPage {
+	nextKey := prefix
+	if opts.StartKey != "" {
+		nextKey = opts.StartKey
+	}
+	return &kvPage{
+		ctx:        ctx,
+		etcdClient: etcdClient,
+		rangeEnd:   prefixRangeEnd(prefix),
+		opts:       opts,
+		rev:        rev,
+		nextKey:    nextKey,
+	}
+}
+
+func (p *kvPage) fetch() error {
+	// The range itself must always be walked in key order: the cursor
+	// (nextKey) advances past the last key byte the range returned, so a
+	// page fetched in any other order could scatter keys anywhere in the
+	// range, leaving maxKey well short of covering everything the range
+	// actually contains and permanently skipping the rest on later pages.
+	// opts.sortBy() is applied afterward, as a page-local sort over the
+	// buffer, to preserve the collection's historical per-page ordering.
+	opts := []etcd.OpOption{
+		etcd.WithRange(p.rangeEnd),
+		etcd.WithLimit(p.opts.pageSize()),
+		etcd.WithSort(etcd.SortByKey, etcd.SortAscend),
+	}
+	if p.rev != 0 {
+		opts = append(opts, etcd.WithRev(p.rev))
+	}
+	resp, err := p.etcdClient.Get(p.ctx, p.nextKey, opts...)
+	if err != nil {
+		return err
+	}
+	if p.rev == 0 {
+		// Pin every subsequent page to the revision of the first
+		// page's read, so the whole listing is a consistent
+		// snapshot even if writes land on the collection while we
+		// page through it.
+		p.rev = resp.Header.Revision
+	}
+	if int64(len(resp.Kvs)) < p.opts.pageSize() {
+		p.done = true
+	} else {
+		p.nextKey = string(append(maxKey(resp.Kvs), 0))
+	}
+	sortKVs(resp.Kvs, p.opts.sortBy())
+	p.buffer = resp.Kvs
+	p.bufIdx = 0
+	return nil
+}
+
+func maxKey(kvs []*mvccpb.KeyValue) []byte {
+	max := kvs[0].Key
+	for _, kv := range kvs[1:] {
+		if string(kv.Key) > string(max) {
+			max = kv.Key
+		}
+	}
+	return append([]byte{}, max...)
+}
+
+// sortKVs reorders kvs in place according to by, descending (matching the
+// collection's historical ordering). It's only ever applied within a
+// single page -- see the comment on ListOptions.SortBy.
+func sortKVs(kvs []*mvccpb.KeyValue, by etcd.SortTarget) {
+	var less func(i, j int) bool
+	switch by {
+	case etcd.SortByKey:
+		less = func(i, j int) bool { return string(kvs[i].Key) > string(kvs[j].Key) }
+	case etcd.SortByCreateRevision:
+		less = func(i, j int) bool { return kvs[i].CreateRevision > kvs[j].CreateRevision }
+	case etcd.SortByVersion:
+		less = func(i, j int) bool { return kvs[i].Version > kvs[j].Version }
+	case etcd.SortByValue:
+		less = func(i, j int) bool { return string(kvs[i].Value) > string(kvs[j].Value) }
+	default: // SortByModRevision
+		less = func(i, j int) bool { return kvs[i].ModRevision > kvs[j].ModRevision }
+	}
+	sort.SliceStable(kvs, less)
+}
+
+// next returns the next KV in the range, fetching additional pages as
+// needed, or (nil, nil) once the range is exhausted.
+func (p *kvPage) next() (*mvccpb.KeyValue, error) {
+	for p.bufIdx >= len(p.buffer) {
+		if p.done {
+			return nil, nil
+		}
+		if err := p.fetch(); err != nil {
+			return nil, err
+		}
+		if len(p.buffer) == 0 {
+			return nil, nil
+		}
+	}
+	kv := p.buffer[p.bufIdx]
+	p.bufIdx++
+	return kv, nil
+}
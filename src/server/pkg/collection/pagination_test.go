@@ -0,0 +1,35 @@
+package collection
+
+import (
+	"testing"
+
+	etcd "github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+)
+
+func TestSortKVsModRevisionDescending(t *testing.T) {
+	kvs := []*mvccpb.KeyValue{
+		{Key: []byte("a"), ModRevision: 1},
+		{Key: []byte("b"), ModRevision: 3},
+		{Key: []byte("c"), ModRevision: 2},
+	}
+	sortKVs(kvs, etcd.SortByModRevision)
+	got := []string{string(kvs[0].Key), string(kvs[1].Key), string(kvs[2].Key)}
+	want := []string{"b", "c", "a"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("sortKVs(ModRevision) = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestMaxKeyIndependentOfInputOrder(t *testing.T) {
+	kvs := []*mvccpb.KeyValue{
+		{Key: []byte("b")},
+		{Key: []byte("z")},
+		{Key: []byte("a")},
+	}
+	if got := string(maxKey(kvs)); got != "z" {
+		t.Fatalf("maxKey = %q, want %q", got, "z")
+	}
+}
@@ -0,0 +1,67 @@
+package collection
+
+import (
+	"bytes"
+	"hash/crc32"
+	"io"
+	"testing"
+)
+
+func TestRecordRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeRecord(&buf, recordKV, []byte("foo"), []byte("bar")); err != nil {
+		t.Fatalf("writeRecord: %v", err)
+	}
+	if err := writeRecordHeader(&buf, recordEnd, 0, 0); err != nil {
+		t.Fatalf("writeRecordHeader: %v", err)
+	}
+
+	kind, key, value, err := readRecord(&buf)
+	if err != nil {
+		t.Fatalf("readRecord: %v", err)
+	}
+	if kind != recordKV || string(key) != "foo" || string(value) != "bar" {
+		t.Fatalf("readRecord = (%v, %q, %q), want (recordKV, \"foo\", \"bar\")", kind, key, value)
+	}
+
+	kind, _, _, err = readRecord(&buf)
+	if err != nil {
+		t.Fatalf("readRecord (end): %v", err)
+	}
+	if kind != recordEnd {
+		t.Fatalf("readRecord = %v, want recordEnd", kind)
+	}
+}
+
+// TestCorruptingAFrameChangesItsCRC32 only confirms that flipping a byte
+// in a Snapshot-framed stream changes the CRC32 computed over it -- a
+// property of hash/crc32's collision resistance, not of how Restore
+// actually wires that check up. It does NOT exercise Restore, and so does
+// NOT prove Restore rejects a corrupted snapshot with
+// ErrSnapshotChecksumMismatch, or that RestoreReplace clears __index_*
+// prefixes: this trimmed tree has no STM/etcd.Client fake to drive
+// Restore end-to-end, and nothing here confirms one exists in the real
+// repo either. That gap should be closed with a real Restore test using
+// whatever fake the rest of the collection package's real test suite
+// uses, once this change lands somewhere that suite is visible.
+func TestCorruptingAFrameChangesItsCRC32(t *testing.T) {
+	var buf bytes.Buffer
+	checksum := crc32.NewIEEE()
+	mw := io.MultiWriter(&buf, checksum)
+	if err := writeRecord(mw, recordKV, []byte("foo"), []byte("bar")); err != nil {
+		t.Fatalf("writeRecord: %v", err)
+	}
+	if err := writeRecordHeader(mw, recordEnd, 0, 0); err != nil {
+		t.Fatalf("writeRecordHeader: %v", err)
+	}
+	want := checksum.Sum32()
+
+	corrupted := append([]byte{}, buf.Bytes()...)
+	corrupted[0] ^= 0xff
+
+	recomputed := crc32.NewIEEE()
+	recomputed.Write(corrupted)
+	if recomputed.Sum32() == want {
+		t.Fatalf("corrupting a byte didn't change the checksum")
+	}
+}
@@ -0,0 +1,28 @@
+package collection
+
+import "github.com/gogo/protobuf/proto"
+
+// testMessage is a minimal hand-rolled proto.Message used by this
+// package's tests. It stands in for a real PPS/PFS proto (e.g. Pipeline
+// or Commit) without requiring those packages as a test dependency: it
+// has a scalar field, a repeated sub-message field (mirroring something
+// like Pipeline.Provenance), and can be nested arbitrarily deep, which
+// the depth-limit tests need.
+type testMessage struct {
+	Name     string         `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Children []*testMessage `protobuf:"bytes,2,rep,name=children" json:"children,omitempty"`
+}
+
+func (m *testMessage) Reset()         { *m = testMessage{} }
+func (m *testMessage) String() string { return proto.CompactTextString(m) }
+func (m *testMessage) ProtoMessage()  {}
+
+// nestedTestMessage builds a testMessage that's depth levels deep, via a
+// single chain of Children, for exercising MaxProtoDepth.
+func nestedTestMessage(depth int) *testMessage {
+	msg := &testMessage{Name: "leaf"}
+	for i := 0; i < depth; i++ {
+		msg = &testMessage{Name: "node", Children: []*testMessage{msg}}
+	}
+	return msg
+}
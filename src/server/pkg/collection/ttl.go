@@ -0,0 +1,212 @@
+package collection
+
+import (
+	"container/heap"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pachyderm/pachyderm/src/server/pkg/watch"
+)
+
+// ttlEntry is one key's position in the expiration heap.
+type ttlEntry struct {
+	key      string
+	expireAt time.Time
+	index    int // maintained by container/heap
+}
+
+// ttlHeap is a min-heap of ttlEntries ordered by expireAt, with an index
+// on top so that an in-flight entry can be updated or removed in
+// O(log n) when a key is refreshed, overwritten, or deleted.
+type ttlHeap struct {
+	mu      sync.Mutex
+	entries ttlEntryHeap
+	byKey   map[string]*ttlEntry
+}
+
+func newTTLHeap() *ttlHeap {
+	return &ttlHeap{
+		byKey: make(map[string]*ttlEntry),
+	}
+}
+
+// Add schedules (or reschedules) key to expire ttlSeconds from now.
+func (h *ttlHeap) Add(key string, ttlSeconds int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	expireAt := time.Now().Add(time.Duration(ttlSeconds) * time.Second)
+	if entry, ok := h.byKey[key]; ok {
+		entry.expireAt = expireAt
+		heap.Fix(&h.entries, entry.index)
+		return
+	}
+	entry := &ttlEntry{key: key, expireAt: expireAt}
+	heap.Push(&h.entries, entry)
+	h.byKey[key] = entry
+}
+
+// Remove cancels any pending expiration for key, e.g. because it was
+// deleted or overwritten with a Put that carries no TTL.
+func (h *ttlHeap) Remove(key string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.removeLocked(key)
+}
+
+func (h *ttlHeap) removeLocked(key string) {
+	entry, ok := h.byKey[key]
+	if !ok {
+		return
+	}
+	heap.Remove(&h.entries, entry.index)
+	delete(h.byKey, key)
+}
+
+// RemovePrefix cancels every pending expiration for a key under prefix,
+// e.g. because DeleteAll removed everything under a collection's prefix
+// in one shot without going through Remove key by key.
+func (h *ttlHeap) RemovePrefix(prefix string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for key := range h.byKey {
+		if strings.HasPrefix(key, prefix) {
+			h.removeLocked(key)
+		}
+	}
+}
+
+// next returns the entry with the soonest expiration, or nil if the heap
+// is empty.
+func (h *ttlHeap) next() *ttlEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.entries) == 0 {
+		return nil
+	}
+	return h.entries[0]
+}
+
+// pop removes and returns the entry with the soonest expiration if it has
+// already expired, or nil otherwise.
+func (h *ttlHeap) pop() *ttlEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.entries) == 0 || h.entries[0].expireAt.After(time.Now()) {
+		return nil
+	}
+	entry := heap.Pop(&h.entries).(*ttlEntry)
+	delete(h.byKey, entry.key)
+	return entry
+}
+
+// ttlEntryHeap implements container/heap.Interface over *ttlEntry,
+// ordered by expireAt.
+type ttlEntryHeap []*ttlEntry
+
+func (h ttlEntryHeap) Len() int           { return len(h) }
+func (h ttlEntryHeap) Less(i, j int) bool { return h[i].expireAt.Before(h[j].expireAt) }
+func (h ttlEntryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *ttlEntryHeap) Push(x interface{}) {
+	entry := x.(*ttlEntry)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *ttlEntryHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	entry := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return entry
+}
+
+// expiredEventSubs fans out expiration events to every active Watch()
+// call on a collection. A single shared channel can't do this safely: Go
+// hands a channel send to one arbitrary blocked receiver, so with more
+// than one watcher only one of them would ever see a given expiration,
+// and with zero watchers attached the send would block forever, wedging
+// watchExpirations for the rest of the collection's life. Each
+// subscriber gets its own buffered channel and a non-blocking send, so a
+// slow or absent watcher can never stall expiration delivery to the
+// others (it just falls back to etcd's own lease GC + EventDelete, as
+// documented on Watch).
+type expiredEventSubs struct {
+	mu   sync.Mutex
+	subs map[int]chan *watch.Event
+	next int
+}
+
+func newExpiredEventSubs() *expiredEventSubs {
+	return &expiredEventSubs{subs: make(map[int]chan *watch.Event)}
+}
+
+// subscribe registers a new subscriber and returns its channel along with
+// an id to pass to unsubscribe once the caller is done watching.
+func (s *expiredEventSubs) subscribe() (id int, ch chan *watch.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id = s.next
+	s.next++
+	ch = make(chan *watch.Event, 1)
+	s.subs[id] = ch
+	return id, ch
+}
+
+func (s *expiredEventSubs) unsubscribe(id int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.subs, id)
+}
+
+// broadcast delivers ev to every current subscriber without blocking; a
+// subscriber that isn't keeping up simply misses this one.
+func (s *expiredEventSubs) broadcast(ev *watch.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, ch := range s.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// watchExpirations runs for the lifetime of the collection, proactively
+// emitting a local EventExpired for each key as its TTL elapses, instead
+// of waiting for etcd to garbage collect the lease and for a watcher to
+// notice the deletion.
+func (c *collection) watchExpirations() {
+	// Nothing wakes this loop when a key is added (or rescheduled sooner)
+	// while it sleeps, so it never sleeps longer than maxWait before
+	// looking at the heap's head again.
+	const maxWait = time.Second
+	timer := time.NewTimer(maxWait)
+	defer timer.Stop()
+	for {
+		wait := maxWait
+		if next := c.expirations.next(); next != nil {
+			if untilNext := time.Until(next.expireAt); untilNext < wait {
+				wait = untilNext
+			}
+			if wait < 0 {
+				wait = 0
+			}
+		}
+		timer.Reset(wait)
+		<-timer.C
+
+		for entry := c.expirations.pop(); entry != nil; entry = c.expirations.pop() {
+			c.expiredSubs.broadcast(&watch.Event{
+				Key:  []byte(entry.key),
+				Type: watch.EventExpired,
+			})
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package collection
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pachyderm/pachyderm/src/server/pkg/watch"
+)
+
+func TestTTLHeapOrdersByExpiration(t *testing.T) {
+	h := newTTLHeap()
+	h.Add("soon", 1)
+	h.Add("later", 100)
+	h.Add("soonest", 0)
+
+	if next := h.next(); next == nil || next.key != "soonest" {
+		t.Fatalf("next() = %v, want \"soonest\"", next)
+	}
+}
+
+func TestTTLHeapAddReschedulesExistingKey(t *testing.T) {
+	h := newTTLHeap()
+	h.Add("a", 100)
+	h.Add("b", 1)
+	if next := h.next(); next == nil || next.key != "b" {
+		t.Fatalf("next() = %v, want \"b\"", next)
+	}
+
+	// Rescheduling "a" to expire sooner than "b" should make it the new
+	// head, in place, without leaving a duplicate entry behind.
+	h.Add("a", 0)
+	if next := h.next(); next == nil || next.key != "a" {
+		t.Fatalf("next() after reschedule = %v, want \"a\"", next)
+	}
+	if len(h.entries) != 2 {
+		t.Fatalf("len(entries) = %d, want 2 (rescheduling must not duplicate)", len(h.entries))
+	}
+}
+
+func TestTTLHeapRemove(t *testing.T) {
+	h := newTTLHeap()
+	h.Add("a", 0)
+	h.Add("b", 0)
+	h.Remove("a")
+
+	if _, ok := h.byKey["a"]; ok {
+		t.Fatalf("Remove left \"a\" in byKey")
+	}
+	if len(h.entries) != 1 || h.entries[0].key != "b" {
+		t.Fatalf("entries after Remove = %v, want just \"b\"", h.entries)
+	}
+}
+
+func TestTTLHeapPopOnlyReturnsExpiredEntries(t *testing.T) {
+	h := newTTLHeap()
+	h.Add("future", 3600)
+	if entry := h.pop(); entry != nil {
+		t.Fatalf("pop() = %v, want nil (not yet expired)", entry)
+	}
+
+	h.Add("past", 0)
+	entry := h.pop()
+	if entry == nil || entry.key != "past" {
+		t.Fatalf("pop() = %v, want \"past\"", entry)
+	}
+	if _, ok := h.byKey["past"]; ok {
+		t.Fatalf("pop left \"past\" in byKey")
+	}
+}
+
+func TestTTLHeapRemovePrefix(t *testing.T) {
+	h := newTTLHeap()
+	h.Add("foo/a", 0)
+	h.Add("foo/b", 0)
+	h.Add("bar/a", 0)
+	h.RemovePrefix("foo/")
+
+	if _, ok := h.byKey["foo/a"]; ok {
+		t.Fatalf("RemovePrefix left \"foo/a\" in byKey")
+	}
+	if _, ok := h.byKey["foo/b"]; ok {
+		t.Fatalf("RemovePrefix left \"foo/b\" in byKey")
+	}
+	if _, ok := h.byKey["bar/a"]; !ok {
+		t.Fatalf("RemovePrefix removed \"bar/a\", which doesn't share the prefix")
+	}
+	if len(h.entries) != 1 {
+		t.Fatalf("len(entries) = %d, want 1", len(h.entries))
+	}
+}
+
+// TestExpiredEventSubsBroadcastReachesEverySubscriber confirms that two
+// concurrent subscribers (standing in for two Watch() callers) each get
+// their own copy of a broadcast event, rather than one of them winning an
+// arbitrary race for the only delivery, as a single shared channel would.
+func TestExpiredEventSubsBroadcastReachesEverySubscriber(t *testing.T) {
+	s := newExpiredEventSubs()
+	id1, ch1 := s.subscribe()
+	defer s.unsubscribe(id1)
+	id2, ch2 := s.subscribe()
+	defer s.unsubscribe(id2)
+
+	ev := &watch.Event{Key: []byte("foo"), Type: watch.EventExpired}
+	s.broadcast(ev)
+
+	select {
+	case got := <-ch1:
+		if got != ev {
+			t.Fatalf("ch1 got %v, want %v", got, ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("subscriber 1 never received the broadcast event")
+	}
+	select {
+	case got := <-ch2:
+		if got != ev {
+			t.Fatalf("ch2 got %v, want %v", got, ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("subscriber 2 never received the broadcast event")
+	}
+}
+
+// TestExpiredEventSubsBroadcastNeverBlocks confirms broadcasting to a
+// subscriber with no room left (or no subscribers at all) doesn't block,
+// so a slow or absent watcher can never wedge the sender.
+func TestExpiredEventSubsBroadcastNeverBlocks(t *testing.T) {
+	s := newExpiredEventSubs()
+	done := make(chan struct{})
+	go func() {
+		// No subscribers at all: the old shared-channel design would
+		// block here forever.
+		s.broadcast(&watch.Event{Type: watch.EventExpired})
+
+		id, _ := s.subscribe()
+		defer s.unsubscribe(id)
+		// The subscriber never drains its one-slot channel, so the
+		// first send fills it and the second must also not block.
+		s.broadcast(&watch.Event{Type: watch.EventExpired})
+		s.broadcast(&watch.Event{Type: watch.EventExpired})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("broadcast blocked with a slow/absent subscriber")
+	}
+}
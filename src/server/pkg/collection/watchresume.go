@@ -0,0 +1,129 @@
+package collection
+
+import (
+	"github.com/pachyderm/pachyderm/src/server/pkg/watch"
+
+	etcd "github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+)
+
+// WatchFrom is like Watch, but resumes from rev instead of starting at
+// "now": every Event carries the ModRevision it was generated at, so a
+// consumer (e.g. the PPS master or a PFS pipeline controller) can
+// checkpoint its progress and call WatchFrom(lastSeenRev+1) to pick back
+// up after a restart without missing or redelivering events.
+//
+// If etcd has compacted away rev because the watcher fell too far behind,
+// the watch is transparently restarted as a Get of the collection's
+// current state: one synthetic EventPut per key present at that revision,
+// followed by a single EventCompacted so the consumer knows it needs to
+// reconcile from scratch rather than apply a diff. This mirrors the
+// reconnect-on-compaction handling in etcd's own clientv3 watch retry
+// logic.
+func (c *readonlyCollection) WatchFrom(rev int64) watch.EventChan {
+	eventCh := make(chan *watch.Event)
+	go c.watchFrom(rev, eventCh)
+	return eventCh
+}
+
+func (c *readonlyCollection) watchFrom(rev int64, eventCh chan *watch.Event) {
+	defer close(eventCh)
+	watchCh := c.etcdClient.Watch(c.ctx, c.prefix, etcd.WithPrefix(), etcd.WithRev(rev))
+	for {
+		select {
+		case resp, ok := <-watchCh:
+			if !ok {
+				return
+			}
+			if err := resp.Err(); err != nil {
+				if !isCompactionError(resp) {
+					c.send(eventCh, &watch.Event{Type: watch.EventError, Err: err})
+					return
+				}
+				resyncRev, ok := c.resyncAfterCompaction(eventCh)
+				if !ok {
+					return
+				}
+				watchCh = c.etcdClient.Watch(c.ctx, c.prefix, etcd.WithPrefix(), etcd.WithRev(resyncRev+1))
+				continue
+			}
+			for _, ev := range resp.Events {
+				if !c.send(eventCh, etcdEventToWatchEvent(ev)) {
+					return
+				}
+			}
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// resyncAfterCompaction re-emits the collection's entire current state as
+// synthetic EventPuts followed by an EventCompacted, and returns the
+// revision that state was read at so the caller can resume watching from
+// rev+1. It returns ok=false if the context was cancelled mid-resync.
+func (c *readonlyCollection) resyncAfterCompaction(eventCh chan *watch.Event) (rev int64, ok bool) {
+	resp, err := c.etcdClient.Get(c.ctx, c.prefix, etcd.WithPrefix())
+	if err != nil {
+		c.send(eventCh, &watch.Event{Type: watch.EventError, Err: err})
+		return 0, false
+	}
+	for _, kv := range resp.Kvs {
+		if !c.send(eventCh, kvToPutEvent(kv)) {
+			return 0, false
+		}
+	}
+	if !c.send(eventCh, &watch.Event{Type: watch.EventCompacted, Rev: resp.Header.Revision}) {
+		return 0, false
+	}
+	return resp.Header.Revision, true
+}
+
+// send delivers ev on eventCh, returning false instead of blocking forever
+// if the collection's context is cancelled first.
+func (c *readonlyCollection) send(eventCh chan *watch.Event, ev *watch.Event) bool {
+	select {
+	case eventCh <- ev:
+		return true
+	case <-c.ctx.Done():
+		return false
+	}
+}
+
+// isCompactionError reports whether resp failed because the watcher fell
+// behind a revision etcd has since compacted away, rather than some other
+// watch failure. etcd signals this by setting CompactRevision to the
+// earliest revision it can still serve, alongside the error returned by
+// resp.Err().
+func isCompactionError(resp etcd.WatchResponse) bool {
+	return resp.CompactRevision != 0
+}
+
+// etcdEventToWatchEvent translates a single etcd watch event into this
+// package's watch.Event, pulled out of watchFrom's loop so it can be unit
+// tested without a live etcd watch channel.
+func etcdEventToWatchEvent(ev *etcd.Event) *watch.Event {
+	watchEv := &watch.Event{
+		Key: ev.Kv.Key,
+		Rev: ev.Kv.ModRevision,
+	}
+	switch ev.Type {
+	case etcd.EventTypePut:
+		watchEv.Type = watch.EventPut
+		watchEv.Value = ev.Kv.Value
+	case etcd.EventTypeDelete:
+		watchEv.Type = watch.EventDelete
+	}
+	return watchEv
+}
+
+// kvToPutEvent wraps kv as a synthetic EventPut, as used by
+// resyncAfterCompaction to replay a collection's entire current state.
+func kvToPutEvent(kv *mvccpb.KeyValue) *watch.Event {
+	return &watch.Event{
+		Key:   kv.Key,
+		Value: kv.Value,
+		Rev:   kv.ModRevision,
+		Type:  watch.EventPut,
+	}
+}
@@ -0,0 +1,61 @@
+package collection
+
+import (
+	"testing"
+
+	"github.com/pachyderm/pachyderm/src/server/pkg/watch"
+
+	etcd "github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+)
+
+func TestEtcdEventToWatchEventPut(t *testing.T) {
+	ev := &etcd.Event{
+		Type: etcd.EventTypePut,
+		Kv:   &mvccpb.KeyValue{Key: []byte("foo"), Value: []byte("bar"), ModRevision: 42},
+	}
+	got := etcdEventToWatchEvent(ev)
+	if got.Type != watch.EventPut {
+		t.Fatalf("Type = %v, want EventPut", got.Type)
+	}
+	if string(got.Key) != "foo" || string(got.Value) != "bar" || got.Rev != 42 {
+		t.Fatalf("got = %+v, want Key=foo Value=bar Rev=42", got)
+	}
+}
+
+func TestEtcdEventToWatchEventDelete(t *testing.T) {
+	ev := &etcd.Event{
+		Type: etcd.EventTypeDelete,
+		Kv:   &mvccpb.KeyValue{Key: []byte("foo"), ModRevision: 43},
+	}
+	got := etcdEventToWatchEvent(ev)
+	if got.Type != watch.EventDelete {
+		t.Fatalf("Type = %v, want EventDelete", got.Type)
+	}
+	if string(got.Key) != "foo" || got.Rev != 43 {
+		t.Fatalf("got = %+v, want Key=foo Rev=43", got)
+	}
+	if got.Value != nil {
+		t.Fatalf("Value = %q, want nil on a delete event", got.Value)
+	}
+}
+
+func TestKVToPutEvent(t *testing.T) {
+	kv := &mvccpb.KeyValue{Key: []byte("foo"), Value: []byte("bar"), ModRevision: 7}
+	got := kvToPutEvent(kv)
+	if got.Type != watch.EventPut {
+		t.Fatalf("Type = %v, want EventPut", got.Type)
+	}
+	if string(got.Key) != "foo" || string(got.Value) != "bar" || got.Rev != 7 {
+		t.Fatalf("got = %+v, want Key=foo Value=bar Rev=7", got)
+	}
+}
+
+func TestIsCompactionError(t *testing.T) {
+	if isCompactionError(etcd.WatchResponse{}) {
+		t.Fatalf("isCompactionError(zero value) = true, want false")
+	}
+	if !isCompactionError(etcd.WatchResponse{CompactRevision: 5}) {
+		t.Fatalf("isCompactionError(CompactRevision=5) = false, want true")
+	}
+}